@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// vaCAALineRE matches a VA log line recording the result of a CAA check. It
+// captures the name checked, whether any CAA records were present for that
+// name, and whether issuance was actually permitted by those records.
+var vaCAALineRE = regexp.MustCompile(`Checked CAA records for ([a-z0-9-.*]+), ` +
+	`\[Present: (true|false), Valid for issuance: (true|false)\]`)
+
+// caaCheck is a single CAA check, as recorded in a VA log line.
+type caaCheck struct {
+	name string
+	time time.Time
+
+	// present is true if any CAA records were found for name.
+	present bool
+	// valid is true if the CAA records found (or their absence) actually
+	// permitted issuance. A CAA check only covers an issuance -- i.e. counts
+	// as evidence that the issuance was properly authorized -- if valid is
+	// true.
+	valid bool
+}
+
+// parseCAACheck parses a single VA log line. If the line isn't a CAA check
+// line, it returns ok == false.
+func parseCAACheck(line string) (check caaCheck, ok bool, err error) {
+	matches := vaCAALineRE.FindStringSubmatch(line)
+	if matches == nil {
+		return caaCheck{}, false, nil
+	}
+	if len(matches) != 4 {
+		return caaCheck{}, false, fmt.Errorf("unexpected number of regex matches")
+	}
+
+	checkTime, err := parseTimestamp(line)
+	if err != nil {
+		return caaCheck{}, false, fmt.Errorf("failed to parse timestamp: %w", err)
+	}
+
+	return caaCheck{
+		name:    matches[1],
+		time:    checkTime,
+		present: matches[2] == "true",
+		valid:   matches[3] == "true",
+	}, true, nil
+}
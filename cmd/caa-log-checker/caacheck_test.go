@@ -0,0 +1,70 @@
+package main
+
+import "testing"
+
+const testTimestamp = "2024-01-15T12:00:00.123456+00:00"
+
+func TestParseCAACheck(t *testing.T) {
+	wantTime, err := parseTimestamp(testTimestamp)
+	if err != nil {
+		t.Fatalf("parsing test timestamp: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		line   string
+		wantOK bool
+		want   caaCheck
+	}{
+		{
+			name:   "present and valid",
+			line:   testTimestamp + " Checked CAA records for example.com, [Present: true, Valid for issuance: true]",
+			wantOK: true,
+			want:   caaCheck{name: "example.com", present: true, valid: true},
+		},
+		{
+			name:   "absent is still valid coverage",
+			line:   testTimestamp + " Checked CAA records for example.com, [Present: false, Valid for issuance: true]",
+			wantOK: true,
+			want:   caaCheck{name: "example.com", present: false, valid: true},
+		},
+		{
+			name:   "present but denies issuance",
+			line:   testTimestamp + " Checked CAA records for example.com, [Present: true, Valid for issuance: false]",
+			wantOK: true,
+			want:   caaCheck{name: "example.com", present: true, valid: false},
+		},
+		{
+			name:   "wildcard name",
+			line:   testTimestamp + " Checked CAA records for *.example.com, [Present: true, Valid for issuance: true]",
+			wantOK: true,
+			want:   caaCheck{name: "*.example.com", present: true, valid: true},
+		},
+		{
+			name:   "unrelated line",
+			line:   testTimestamp + ` Certificate request - successful JSON={"key":"value"}`,
+			wantOK: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check, ok, err := parseCAACheck(tt.line)
+			if err != nil {
+				t.Fatalf("parseCAACheck: %v", err)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if check.name != tt.want.name || check.present != tt.want.present || check.valid != tt.want.valid {
+				t.Errorf("got %+v, want name=%q present=%v valid=%v", check, tt.want.name, tt.want.present, tt.want.valid)
+			}
+			if !check.time.Equal(wantTime) {
+				t.Errorf("time = %v, want %v", check.time, wantTime)
+			}
+		})
+	}
+}
@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertRecord is the structured record emitted to stdout, one per line, when
+// an issuance reaches -max-wait without being covered by a CAA check.
+type alertRecord struct {
+	Time      time.Time `json:"time"`
+	Serial    string    `json:"serial"`
+	Name      string    `json:"name"`
+	Requester int64     `json:"requester"`
+}
+
+// pendingIssuance is a single issuance awaiting a covering CAA check, as
+// tracked by a correlationEngine.
+type pendingIssuance struct {
+	serial       string
+	name         string
+	requester    int64
+	issuanceTime time.Time
+}
+
+// correlationEngine incrementally matches issuance events against CAA check
+// events, the same way loadIssuanceLogs and processCAALogs do in batch mode,
+// but over a live, unbounded stream fed by tailed log files. An issuance is
+// evicted from the pending set either when a covering CAA check for its name
+// arrives, or when it has been pending for longer than maxWait, at which
+// point it is emitted as an alert.
+type correlationEngine struct {
+	timeTolerance time.Duration
+	maxWait       time.Duration
+	out           io.Writer
+	metrics       *metrics
+
+	mu sync.Mutex
+	// pending maps a name to the issuances awaiting a covering CAA check for
+	// that name.
+	pending map[string][]pendingIssuance
+}
+
+func newCorrelationEngine(timeTolerance, maxWait time.Duration, out io.Writer, m *metrics) *correlationEngine {
+	return &correlationEngine{
+		timeTolerance: timeTolerance,
+		maxWait:       maxWait,
+		out:           out,
+		metrics:       m,
+		pending:       map[string][]pendingIssuance{},
+	}
+}
+
+// addIssuance records a freshly-observed issuance for each of its names.
+func (e *correlationEngine) addIssuance(ie issuanceEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, name := range ie.Names {
+		e.pending[name] = append(e.pending[name], pendingIssuance{
+			serial:       ie.SerialNumber,
+			name:         name,
+			requester:    ie.Requester,
+			issuanceTime: ie.issuanceTime,
+		})
+	}
+	if e.metrics != nil {
+		e.metrics.issuances.Add(1)
+	}
+}
+
+// addCheck records a freshly-observed CAA check, evicting any issuance for
+// name (and, if present is false, for any of name's parent domains) that it
+// covers.
+func (e *correlationEngine) addCheck(name string, checkTime time.Time, present bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.evictCoveredLocked(name, checkTime)
+
+	// If the CAA check didn't find any CAA records for w.x.y.z, then that means
+	// that we checked the CAA records for x.y.z and y.z as well, and are
+	// covered for any issuance for those names. The last label (z) on its own
+	// is not covered.
+	if !present {
+		labels := strings.Split(name, ".")
+		for i := 1; i < len(labels)-1; i++ {
+			e.evictCoveredLocked(strings.Join(labels[i:], "."), checkTime)
+		}
+	}
+}
+
+func (e *correlationEngine) evictCoveredLocked(name string, checkTime time.Time) {
+	var remaining []pendingIssuance
+	for _, pi := range e.pending[name] {
+		diff := pi.issuanceTime.Sub(checkTime)
+		if diff < -e.timeTolerance || diff > 8*time.Hour+e.timeTolerance {
+			remaining = append(remaining, pi)
+		} else if e.metrics != nil {
+			e.metrics.observeCAACheckLag(diff)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(e.pending, name)
+	} else {
+		e.pending[name] = remaining
+	}
+}
+
+// sweep emits an alert for, and removes, every pending issuance that has been
+// waiting for a covering CAA check for longer than maxWait.
+func (e *correlationEngine) sweep(now time.Time) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for name, issuances := range e.pending {
+		var remaining []pendingIssuance
+		for _, pi := range issuances {
+			if now.Sub(pi.issuanceTime) <= e.maxWait {
+				remaining = append(remaining, pi)
+				continue
+			}
+			if err := e.emitAlert(pi); err != nil {
+				return err
+			}
+		}
+		if len(remaining) == 0 {
+			delete(e.pending, name)
+		} else {
+			e.pending[name] = remaining
+		}
+	}
+	return nil
+}
+
+func (e *correlationEngine) emitAlert(pi pendingIssuance) error {
+	if e.metrics != nil {
+		e.metrics.uncovered.WithLabelValues("no-caa-check").Inc()
+	}
+	return json.NewEncoder(e.out).Encode(alertRecord{
+		Time:      pi.issuanceTime,
+		Serial:    pi.serial,
+		Name:      pi.name,
+		Requester: pi.requester,
+	})
+}
+
+// followReader tails a single log file, surviving logrotate-style renames
+// and truncations. At EOF it polls the underlying path, re-opening it
+// whenever the file has been truncated (copytruncate-style rotation) or
+// replaced by a new file at the same path (rename-and-recreate rotation).
+type followReader struct {
+	path         string
+	pollInterval time.Duration
+
+	f       *os.File
+	reader  *bufio.Reader
+	offset  int64
+	pending []byte
+}
+
+func newFollowReader(path string) (*followReader, error) {
+	fr := &followReader{path: path, pollInterval: time.Second}
+	if err := fr.reopen(); err != nil {
+		return nil, err
+	}
+	return fr, nil
+}
+
+func (fr *followReader) reopen() error {
+	f, err := os.Open(fr.path)
+	if err != nil {
+		return err
+	}
+	if fr.f != nil {
+		fr.f.Close()
+	}
+	fr.f = f
+	fr.reader = bufio.NewReader(f)
+	fr.offset = 0
+	fr.pending = nil
+	return nil
+}
+
+// readLine blocks, polling at pollInterval, until a full line is available,
+// ctx is cancelled, or an unrecoverable error occurs.
+func (fr *followReader) readLine(ctx context.Context) (string, error) {
+	for {
+		chunk, err := fr.reader.ReadString('\n')
+		// ReadString returns any bytes it managed to read even when it also
+		// returns an error, and those bytes are gone from the underlying
+		// bufio.Reader either way -- so a chunk that ends at EOF without a
+		// trailing newline must be retained across calls, not discarded, or
+		// the partial line it contains is lost for good and the next call
+		// returns only the back half of what was really a single line.
+		fr.offset += int64(len(chunk))
+		fr.pending = append(fr.pending, chunk...)
+		if err == nil {
+			line := strings.TrimRight(string(fr.pending), "\n")
+			fr.pending = nil
+			return line, nil
+		}
+		if err != io.EOF {
+			return "", err
+		}
+
+		// At EOF: check whether the file has been truncated in place, or
+		// renamed away and replaced, either of which means we should pick up
+		// reading from a freshly (re-)opened file rather than polling the one
+		// we already have open.
+		if fi, statErr := os.Stat(fr.path); statErr == nil {
+			if fi.Size() < fr.offset {
+				if err := fr.reopen(); err != nil {
+					return "", err
+				}
+				continue
+			}
+			if curFi, err := fr.f.Stat(); err == nil && !os.SameFile(fi, curFi) {
+				if err := fr.reopen(); err != nil {
+					return "", err
+				}
+				continue
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(fr.pollInterval):
+		}
+	}
+}
+
+func (fr *followReader) Close() error {
+	return fr.f.Close()
+}
+
+// followConfig holds the parameters needed to run the checker in -follow
+// mode.
+type followConfig struct {
+	raLogs        []string
+	vaLogs        []string
+	timeTolerance time.Duration
+	maxWait       time.Duration
+	out           io.Writer
+	metrics       *metrics
+}
+
+// followRALog tails the RA log at path, parsing issuance events out of it
+// and feeding them into engine until ctx is cancelled.
+func followRALog(ctx context.Context, path string, engine *correlationEngine) error {
+	fr, err := newFollowReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	defer fr.Close()
+
+	for {
+		line, err := fr.readLine(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("tailing %q: %w", path, err)
+		}
+
+		matches := raIssuanceLineRE.FindStringSubmatch(line)
+		if matches == nil {
+			continue
+		}
+
+		var ie issuanceEvent
+		if err := json.Unmarshal([]byte(matches[1]), &ie); err != nil {
+			return fmt.Errorf("%s: failed to unmarshal JSON: %w", path, err)
+		}
+		ie.issuanceTime, err = parseTimestamp(line)
+		if err != nil {
+			return fmt.Errorf("%s: failed to parse timestamp: %w", path, err)
+		}
+
+		engine.addIssuance(ie)
+	}
+}
+
+// followVALog tails the VA log at path, parsing CAA check events out of it
+// and feeding them into engine until ctx is cancelled.
+func followVALog(ctx context.Context, path string, engine *correlationEngine) error {
+	fr, err := newFollowReader(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", path, err)
+	}
+	defer fr.Close()
+
+	for {
+		line, err := fr.readLine(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return fmt.Errorf("tailing %q: %w", path, err)
+		}
+
+		check, ok, err := parseCAACheck(line)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if !ok {
+			continue
+		}
+
+		// An invalid CAA check (one that denied issuance) doesn't cover
+		// anything, so it's not fed into the correlation engine: the issuance
+		// it pertains to, if any, will simply keep waiting for a valid one, and
+		// eventually alert via -max-wait like any other uncovered issuance.
+		if check.valid {
+			engine.addCheck(check.name, check.time, check.present)
+		}
+	}
+}
+
+// runFollow runs the checker in streaming mode: it tails the RA and VA logs
+// named in cfg indefinitely, correlating issuances against CAA checks as
+// they arrive and emitting a JSON alert to cfg.out for any issuance that
+// goes uncovered for longer than cfg.maxWait. It only returns when ctx is
+// cancelled or a tailed file can no longer be read.
+func runFollow(ctx context.Context, cfg followConfig) error {
+	engine := newCorrelationEngine(cfg.timeTolerance, cfg.maxWait, cfg.out, cfg.metrics)
+
+	// The first error cancels ctx, which stops every other goroutine.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(cfg.raLogs)+len(cfg.vaLogs)+1)
+
+	for _, raLog := range cfg.raLogs {
+		raLog := raLog
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := followRALog(ctx, raLog, engine); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	for _, vaLog := range cfg.vaLogs {
+		vaLog := vaLog
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := followVALog(ctx, vaLog, engine); err != nil {
+				errs <- err
+				cancel()
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if err := engine.sweep(now); err != nil {
+					errs <- err
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestFollowReaderPartialLineAcrossPoll is a regression test for
+// followReader losing the first half of a line that's written in two
+// separate writes straddling a poll: bufio.Reader.ReadString consumes bytes
+// up through EOF even when it returns io.EOF, so a naive implementation that
+// discards those bytes on the EOF branch permanently loses them.
+func TestFollowReaderPartialLineAcrossPoll(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "va.log")
+	if err := os.WriteFile(path, []byte("hello "), 0o644); err != nil {
+		t.Fatalf("writing initial content: %v", err)
+	}
+
+	fr, err := newFollowReader(path)
+	if err != nil {
+		t.Fatalf("newFollowReader: %v", err)
+	}
+	defer fr.Close()
+	fr.pollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	appended := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
+		if err != nil {
+			appended <- err
+			return
+		}
+		defer f.Close()
+		_, err = f.WriteString("world\n")
+		appended <- err
+	}()
+
+	line, err := fr.readLine(ctx)
+	if appendErr := <-appended; appendErr != nil {
+		t.Fatalf("appending to log file: %v", appendErr)
+	}
+	if err != nil {
+		t.Fatalf("readLine: %v", err)
+	}
+	if line != "hello world" {
+		t.Errorf("got %q, want %q", line, "hello world")
+	}
+}
+
+// TestFollowReaderSurvivesTruncate checks that readLine detects
+// copytruncate-style rotation (the file shrinks in place) and resumes
+// reading from the start of the new content, rather than getting stuck
+// polling for bytes past the new, smaller end of file.
+func TestFollowReaderSurvivesTruncate(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "va.log")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 20)+"\n"), 0o644); err != nil {
+		t.Fatalf("writing initial content: %v", err)
+	}
+
+	fr, err := newFollowReader(path)
+	if err != nil {
+		t.Fatalf("newFollowReader: %v", err)
+	}
+	defer fr.Close()
+	fr.pollInterval = 10 * time.Millisecond
+
+	first, err := fr.readLine(context.Background())
+	if err != nil || first != strings.Repeat("x", 20) {
+		t.Fatalf("first readLine = %q, %v", first, err)
+	}
+
+	if err := os.WriteFile(path, []byte("ok\n"), 0o644); err != nil {
+		t.Fatalf("truncating: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	second, err := fr.readLine(ctx)
+	if err != nil {
+		t.Fatalf("readLine after truncate: %v", err)
+	}
+	if second != "ok" {
+		t.Errorf("got %q, want %q", second, "ok")
+	}
+}
+
+func TestCorrelationEngineCoversIssuance(t *testing.T) {
+	var out bytes.Buffer
+	engine := newCorrelationEngine(0, time.Hour, &out, nil)
+
+	issuanceTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine.addIssuance(issuanceEvent{
+		SerialNumber: "01",
+		Names:        []string{"example.com"},
+		Requester:    1,
+		issuanceTime: issuanceTime,
+	})
+	engine.addCheck("example.com", issuanceTime.Add(-time.Minute), true)
+
+	if err := engine.sweep(issuanceTime.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected no alert for a covered issuance, got %q", out.String())
+	}
+}
+
+func TestCorrelationEngineAlertsOnTimeout(t *testing.T) {
+	var out bytes.Buffer
+	engine := newCorrelationEngine(0, time.Hour, &out, nil)
+
+	issuanceTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	engine.addIssuance(issuanceEvent{
+		SerialNumber: "01",
+		Names:        []string{"example.com"},
+		Requester:    1,
+		issuanceTime: issuanceTime,
+	})
+
+	if err := engine.sweep(issuanceTime.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("sweep: %v", err)
+	}
+
+	var alert alertRecord
+	if err := json.Unmarshal(out.Bytes(), &alert); err != nil {
+		t.Fatalf("unmarshaling alert: %v", err)
+	}
+	if alert.Serial != "01" || alert.Name != "example.com" || alert.Requester != 1 {
+		t.Errorf("got %+v", alert)
+	}
+}
+
+// TestRunFollowExitsCleanly exercises the full runFollow fan-out (one
+// goroutine per RA/VA log, plus the sweep goroutine) against logs that
+// already contain an issuance and a CAA check for it, then cancels ctx and
+// checks that every goroutine unwinds without error and nothing was written
+// to out. The sweep runs once a minute, so within this test no alert would be
+// written whether or not the issuance was covered.
+func TestRunFollowExitsCleanly(t *testing.T) {
+	dir := t.TempDir()
+	raPath := filepath.Join(dir, "ra.log")
+	vaPath := filepath.Join(dir, "va.log")
+
+	raLine := testTimestamp + ` Certificate request - successful JSON={"SerialNumber":"01","Names":["example.com"],"Requester":1}` + "\n"
+	vaLine := testTimestamp + " Checked CAA records for example.com, [Present: true, Valid for issuance: true]\n"
+
+	if err := os.WriteFile(raPath, []byte(raLine), 0o644); err != nil {
+		t.Fatalf("writing ra log: %v", err)
+	}
+	if err := os.WriteFile(vaPath, []byte(vaLine), 0o644); err != nil {
+		t.Fatalf("writing va log: %v", err)
+	}
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error, 1)
+	go func() {
+		done <- runFollow(ctx, followConfig{
+			raLogs:        []string{raPath},
+			vaLogs:        []string{vaPath},
+			timeTolerance: 0,
+			maxWait:       time.Hour,
+			out:           &out,
+		})
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("runFollow: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("runFollow did not exit after context cancellation")
+	}
+
+	if out.Len() != 0 {
+		t.Errorf("expected no alert for a covered issuance, got %q", out.String())
+	}
+}
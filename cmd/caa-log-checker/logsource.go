@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// closerFunc adapts a plain func() error to an io.Closer.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// openCompressed opens path and returns a reader over its decompressed
+// contents, sniffing the compression format from the file's leading magic
+// bytes rather than trusting its extension. A file whose magic doesn't match
+// any known compression format is assumed to be plain text.
+func openCompressed(path string) (io.Reader, io.Closer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(f)
+	magic, err := br.Peek(6)
+	if err != nil && err != io.EOF {
+		f.Close()
+		return nil, nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		return gz, closerFunc(func() error {
+			gz.Close()
+			return f.Close()
+		}), nil
+
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		return zr.IOReadCloser(), closerFunc(func() error {
+			zr.Close()
+			return f.Close()
+		}), nil
+
+	case bytes.HasPrefix(magic, xzMagic):
+		xr, err := xz.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, nil, fmt.Errorf("failed to open xz stream: %w", err)
+		}
+		return xr, f, nil
+
+	default:
+		return br, f, nil
+	}
+}
+
+// logSource reads a sequence of log lines out of either a single file (of
+// any of the supported compression formats) or a directory containing a
+// rotated sequence of such files, concatenated in timestamp order as if they
+// were one continuous stream. This is what lets a historical audit be
+// pointed at a whole directory of rotated, possibly differently-compressed
+// boulder-ra/boulder-va logs without pre-processing them by hand.
+//
+// logSource is deliberately not safe for concurrent use by multiple
+// goroutines; each goroutine scanning a file (or directory) should open its
+// own logSource.
+type logSource struct {
+	files   []string
+	nextIdx int
+
+	scanner *bufio.Scanner
+	closer  io.Closer
+	file    string
+	line    int
+	err     error
+}
+
+// openLogSource opens path, which may be a single log file or a directory of
+// rotated log files.
+func openLogSource(path string) (*logSource, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	ls := &logSource{}
+	if fi.IsDir() {
+		ls.files, err = rotatedFilesInDir(path)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		ls.files = []string{path}
+	}
+	return ls, nil
+}
+
+// rotatedFilesInDir returns the regular files directly inside dir, sorted
+// oldest-to-newest by modification time, which is how logrotate (and
+// similar tools) order a rotated sequence of log files.
+func rotatedFilesInDir(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		path    string
+		modTime time.Time
+	}
+	var candidates []candidate
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, candidate{
+			path:    filepath.Join(dir, entry.Name()),
+			modTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].modTime.Before(candidates[j].modTime) })
+
+	files := make([]string, len(candidates))
+	for i, c := range candidates {
+		files[i] = c.path
+	}
+	return files, nil
+}
+
+// Scan advances to the next line, transparently moving on to the next
+// constituent file once the current one is exhausted. It returns false once
+// every file has been read, or on error; call Err to distinguish the two.
+func (ls *logSource) Scan() bool {
+	for {
+		if ls.scanner == nil && !ls.openNext() {
+			return false
+		}
+		if ls.scanner.Scan() {
+			ls.line++
+			return true
+		}
+		if err := ls.scanner.Err(); err != nil {
+			ls.err = fmt.Errorf("%s: %w", ls.file, err)
+			return false
+		}
+		ls.closer.Close()
+		ls.scanner = nil
+	}
+}
+
+func (ls *logSource) openNext() bool {
+	if ls.nextIdx >= len(ls.files) {
+		return false
+	}
+	path := ls.files[ls.nextIdx]
+	ls.nextIdx++
+
+	reader, closer, err := openCompressed(path)
+	if err != nil {
+		ls.err = fmt.Errorf("failed to open %q: %w", path, err)
+		return false
+	}
+	ls.scanner = bufio.NewScanner(reader)
+	ls.closer = closer
+	ls.file = path
+	ls.line = 0
+	return true
+}
+
+// Text returns the most recent line produced by Scan.
+func (ls *logSource) Text() string { return ls.scanner.Text() }
+
+// File returns the path of the constituent file that produced the most
+// recent line from Scan, for use in diagnostics like "file X line N".
+func (ls *logSource) File() string { return ls.file }
+
+// Line returns the 1-indexed line number, within File, of the most recent
+// line produced by Scan.
+func (ls *logSource) Line() int { return ls.line }
+
+// Err returns the first non-EOF error encountered by Scan, if any.
+func (ls *logSource) Err() error { return ls.err }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+func writeTestFile(t *testing.T, path string, contents []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, contents, 0o644); err != nil {
+		t.Fatalf("writing %s: %v", path, err)
+	}
+}
+
+func gzipBytes(t *testing.T, contents []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(contents); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, contents []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("zstd.NewWriter: %v", err)
+	}
+	if _, err := w.Write(contents); err != nil {
+		t.Fatalf("zstd write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("zstd close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func xzBytes(t *testing.T, contents []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("xz.NewWriter: %v", err)
+	}
+	if _, err := w.Write(contents); err != nil {
+		t.Fatalf("xz write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("xz close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// TestOpenCompressed checks that openCompressed dispatches on a file's
+// leading magic bytes, not its extension: every fixture here is written to a
+// path with no extension at all.
+func TestOpenCompressed(t *testing.T) {
+	const want = "line one\nline two\n"
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain text", []byte(want)},
+		{"gzip", gzipBytes(t, []byte(want))},
+		{"zstd", zstdBytes(t, []byte(want))},
+		{"xz", xzBytes(t, []byte(want))},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "log")
+			writeTestFile(t, path, tt.data)
+
+			reader, closer, err := openCompressed(path)
+			if err != nil {
+				t.Fatalf("openCompressed: %v", err)
+			}
+			defer closer.Close()
+
+			got, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("reading decompressed content: %v", err)
+			}
+			if string(got) != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestLogSourceDirectory checks that a directory of rotated log files is
+// read back as a single stream, oldest file first, and that File/Line track
+// which physical file produced the most recent line.
+func TestLogSourceDirectory(t *testing.T) {
+	dir := t.TempDir()
+
+	older := filepath.Join(dir, "boulder-va.log.1")
+	newer := filepath.Join(dir, "boulder-va.log")
+	writeTestFile(t, older, []byte("first\nsecond\n"))
+	writeTestFile(t, newer, []byte("third\nfourth\n"))
+
+	now := time.Now()
+	if err := os.Chtimes(older, now.Add(-time.Hour), now.Add(-time.Hour)); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+	if err := os.Chtimes(newer, now, now); err != nil {
+		t.Fatalf("Chtimes: %v", err)
+	}
+
+	ls, err := openLogSource(dir)
+	if err != nil {
+		t.Fatalf("openLogSource: %v", err)
+	}
+
+	var lines []string
+	for ls.Scan() {
+		lines = append(lines, ls.Text())
+	}
+	if err := ls.Err(); err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+
+	want := []string{"first", "second", "third", "fourth"}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %v", len(lines), len(want), lines)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("line %d: got %q, want %q", i, lines[i], want[i])
+		}
+	}
+
+	if ls.File() != newer {
+		t.Errorf("File() = %q after reading the last line, want %q", ls.File(), newer)
+	}
+	if ls.Line() != 2 {
+		t.Errorf("Line() = %d after reading the last line, want 2", ls.Line())
+	}
+}
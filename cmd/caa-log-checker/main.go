@@ -1,16 +1,16 @@
 package main
 
 import (
-	"bufio"
-	"compress/gzip"
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io"
 	"os"
+	"os/signal"
 	"regexp"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/letsencrypt/boulder/cmd"
@@ -18,9 +18,6 @@ import (
 
 var raIssuanceLineRE = regexp.MustCompile(`Certificate request - successful JSON=(.*)`)
 
-// TODO: Extract the "Valid for issuance: (true|false)" field too.
-var vaCAALineRE = regexp.MustCompile(`Checked CAA records for ([a-z0-9-.*]+), \[Present: (true|false)`)
-
 type issuanceEvent struct {
 	SerialNumber string
 	Names        []string
@@ -29,21 +26,12 @@ type issuanceEvent struct {
 	issuanceTime time.Time
 }
 
-func openFile(path string) (*bufio.Scanner, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return nil, err
-	}
-	var reader io.Reader
-	reader = f
-	if strings.HasSuffix(path, ".gz") {
-		reader, err = gzip.NewReader(f)
-		if err != nil {
-			return nil, err
-		}
-	}
-	scanner := bufio.NewScanner(reader)
-	return scanner, nil
+// issuanceRecord is a single issuance of a certificate for a particular
+// name, as tracked while looking for a covering CAA check.
+type issuanceRecord struct {
+	time      time.Time
+	serial    string
+	requester int64
 }
 
 func parseTimestamp(line string) (time.Time, error) {
@@ -55,36 +43,50 @@ func parseTimestamp(line string) (time.Time, error) {
 	return datestamp, nil
 }
 
-// loadIssuanceLog processes a single issuance (RA) log file. It returns a map
-// of names to slices of timestamps at which certificates for those names were
-// issued.
-// TODO: plumb through earliest and latest for parity with old implementation.
-func loadIssuanceLog(path string) (map[string][]time.Time, error) {
-	scanner, err := openFile(path)
+// inRange reports whether t falls within [earliest, latest). A zero
+// earliest or latest leaves that end of the range unbounded.
+func inRange(t, earliest, latest time.Time) bool {
+	if !earliest.IsZero() && t.Before(earliest) {
+		return false
+	}
+	if !latest.IsZero() && !t.Before(latest) {
+		return false
+	}
+	return true
+}
+
+// loadIssuanceLog processes a single issuance (RA) log file, or, if path is a
+// directory, every rotated log file in it concatenated in timestamp order.
+// Each file's compression, if any, is detected from its contents rather than
+// its name, so gzip, zstd, and xz files are all handled transparently. It
+// returns a map of names to the issuances of certificates for those names,
+// plus a count of the total number of issuances seen (regardless of how many
+// names each covered). Issuances outside of [earliest, latest) are dropped;
+// either may be the zero time.Time to leave that end of the range unbounded.
+func loadIssuanceLog(path string, earliest, latest time.Time) (map[string][]issuanceRecord, int, error) {
+	ls, err := openLogSource(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open %q: %w", path, err)
+		return nil, 0, fmt.Errorf("failed to open %q: %w", path, err)
 	}
 
-	linesCount := 0
 	issuancesCount := 0
 
-	issuanceMap := map[string][]time.Time{}
-	for scanner.Scan() {
-		line := scanner.Text()
-		linesCount++
+	issuanceMap := map[string][]issuanceRecord{}
+	for ls.Scan() {
+		line := ls.Text()
 
 		matches := raIssuanceLineRE.FindStringSubmatch(line)
 		if matches == nil {
 			continue
 		}
 		if len(matches) != 2 {
-			return nil, fmt.Errorf("line %d: unexpected number of regex matches", linesCount)
+			return nil, 0, fmt.Errorf("%s: line %d: unexpected number of regex matches", ls.File(), ls.Line())
 		}
 
 		var ie issuanceEvent
 		err := json.Unmarshal([]byte(matches[1]), &ie)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: failed to unmarshal JSON: %w", linesCount, err)
+			return nil, 0, fmt.Errorf("%s: line %d: failed to unmarshal JSON: %w", ls.File(), ls.Line(), err)
 		}
 
 		// Populate the issuance time from the syslog timestamp, rather than the
@@ -93,108 +95,75 @@ func loadIssuanceLog(path string) (map[string][]time.Time, error) {
 		// generated on the same system, they should be tightly coupled anyway.
 		ie.issuanceTime, err = parseTimestamp(line)
 		if err != nil {
-			return nil, fmt.Errorf("line %d: failed to parse timestamp: %w", linesCount, err)
+			return nil, 0, fmt.Errorf("%s: line %d: failed to parse timestamp: %w", ls.File(), ls.Line(), err)
+		}
+
+		if !inRange(ie.issuanceTime, earliest, latest) {
+			continue
 		}
 
 		issuancesCount++
+		record := issuanceRecord{time: ie.issuanceTime, serial: ie.SerialNumber, requester: ie.Requester}
 		for _, name := range ie.Names {
-			issuanceMap[name] = append(issuanceMap[name], ie.issuanceTime)
+			issuanceMap[name] = append(issuanceMap[name], record)
 		}
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if err := ls.Err(); err != nil {
+		return nil, 0, err
 	}
 
-	return issuanceMap, nil
+	return issuanceMap, issuancesCount, nil
 }
 
-// processCAALog processes a single CAA (VA) log file. It modifies the input map
-// (of issuance names to times, as returned by `loadIssuanceLog`) to remove any
-// timestamps which are covered by (i.e. less than 8 hours after) a CAA check
-// for that name in the log file. It also prunes any names whose slice of
-// issuance times becomes empty.
-func processCAALog(path string, issuances map[string][]time.Time) error {
-	scanner, err := openFile(path)
-	if err != nil {
-		return fmt.Errorf("failed to open %q: %w", path, err)
-	}
-
-	linesCount := 0
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		linesCount++
-
-		matches := vaCAALineRE.FindStringSubmatch(line)
-		if matches == nil {
-			continue
-		}
-		if len(matches) != 3 {
-			return fmt.Errorf("line %d: unexpected number of regex matches", linesCount)
-		}
-		name := matches[1]
-		present := matches[2]
-
-		checkTime, err := parseTimestamp(line)
-		if err != nil {
-			return fmt.Errorf("line %d: failed to parse timestamp: %w", linesCount, err)
-		}
-
-		// TODO: Only remove covered issuance timestamps if the CAA check actually
-		// said that we're allowed to issue (i.e. had "Valid for issuance: true").
-		issuances[name] = removeCoveredTimestamps(issuances[name], checkTime)
-		if len(issuances[name]) == 0 {
-			delete(issuances, name)
-		}
-
-		// If the CAA check didn't find any CAA records for w.x.y.z, then that means
-		// that we checked the CAA records for x.y.z, y.z, and z as well, and are
-		// covered for any issuance for those names.
-		if present == "false" {
-			labels := strings.Split(name, ".")
-			for i := 1; i < len(labels)-1; i++ {
-				tailName := strings.Join(labels[i:], ".")
-				issuances[tailName] = removeCoveredTimestamps(issuances[tailName], checkTime)
-				if len(issuances[tailName]) == 0 {
-					delete(issuances, tailName)
-				}
-			}
+// removeCovered returns a new slice of issuance records which contains all
+// records that are *not* within 8 hours (plus or minus tolerance) after the
+// input timestamp.
+func removeCovered(records []issuanceRecord, cover time.Time, tolerance time.Duration) []issuanceRecord {
+	var r []issuanceRecord
+	for _, record := range records {
+		// Keep the record if it is before the covering timestamp, or more than
+		// 8 hours after the covering timestamp (i.e. if it is *not* covered by
+		// the covering timestamp), allowing tolerance of slop in either
+		// direction.
+		diff := record.time.Sub(cover)
+		if diff < -tolerance || diff > 8*time.Hour+tolerance {
+			r = append(r, record)
 		}
 	}
-
-	return scanner.Err()
+	return r
 }
 
-// removeCoveredTimestamps returns a new slice of timestamps which contains all
-// timestamps that are *not* within 8 hours after the input timestamp.
-// TODO: plumb through time-tolerance to account for slight slop.
-func removeCoveredTimestamps(timestamps []time.Time, cover time.Time) []time.Time {
-	r := make([]time.Time, len(timestamps))
-	for _, ts := range timestamps {
-		// Copy the timestamp into the results slice if it is before the covering
-		// timestamp, or more than 8 hours after the covering timestamp (i.e. if
-		// it is *not* covered by the covering timestamp).
-		diff := ts.Sub(cover)
-		if diff < 0 || diff > 8*time.Hour {
-			ts := ts
-			r = append(r, ts)
+// findCovered returns the subset of records that are covered by (i.e. less
+// than 8 hours, plus or minus tolerance, after) cover.
+func findCovered(records []issuanceRecord, cover time.Time, tolerance time.Duration) []issuanceRecord {
+	var covered []issuanceRecord
+	for _, record := range records {
+		diff := record.time.Sub(cover)
+		if diff >= -tolerance && diff <= 8*time.Hour+tolerance {
+			covered = append(covered, record)
 		}
 	}
-	return r
+	return covered
 }
 
-// formatErrors returns nil if the input map is empty. Otherwise, it returns an
-// error containing a listing of every name and issuance time that was not
-// covered by a CAA check.
-func formatErrors(remaining map[string][]time.Time) error {
-	if len(remaining) == 0 {
+// formatErrors returns nil if uncovered and invalid are both empty.
+// Otherwise, it returns an error listing every name and issuance that either
+// had no CAA check at all (from uncovered), or had a CAA check that
+// explicitly denied issuance (from invalid).
+func formatErrors(uncovered, invalid map[string][]issuanceRecord) error {
+	if len(uncovered) == 0 && len(invalid) == 0 {
 		return nil
 	}
 
-	messages := make([]string, len(remaining))
-	for name, timestamps := range remaining {
-		for _, timestamp := range timestamps {
-			messages = append(messages, fmt.Sprintf("%v: %s", timestamp, name))
+	var messages []string
+	for name, records := range uncovered {
+		for _, record := range records {
+			messages = append(messages, fmt.Sprintf("%v: %s: issued without any CAA check", record.time, name))
+		}
+	}
+	for name, records := range invalid {
+		for _, record := range records {
+			messages = append(messages, fmt.Sprintf("%v: %s: issued despite CAA check denying issuance", record.time, name))
 		}
 	}
 
@@ -205,31 +174,105 @@ func formatErrors(remaining map[string][]time.Time) error {
 func main() {
 	logStdoutLevel := flag.Int("stdout-level", 6, "Minimum severity of messages to send to stdout")
 	logSyslogLevel := flag.Int("syslog-level", 6, "Minimum severity of messages to send to syslog")
-	raLog := flag.String("ra-log", "", "Path to a single boulder-ra log file")
-	vaLogs := flag.String("va-logs", "", "List of paths to boulder-va logs, separated by commas")
+	raLog := flag.String("ra-log", "", "Comma-separated list of paths (optionally containing "+
+		"shell globs, e.g. '/var/log/boulder-ra*.log.gz', or directories of rotated log files "+
+		"to be read in timestamp order) to boulder-ra log files. Gzip, zstd, and xz "+
+		"compression are detected automatically and need not match the file extension.")
+	vaLogs := flag.String("va-logs", "", "Comma-separated list of paths (optionally containing "+
+		"shell globs, or directories of rotated log files to be read in timestamp order) to "+
+		"boulder-va log files. Gzip, zstd, and xz compression are detected automatically and "+
+		"need not match the file extension.")
+	concurrency := flag.Int("concurrency", 4, "Maximum number of log files to process concurrently")
 	timeTolerance := flag.Duration("time-tolerance", 0, "How much slop to allow when comparing timestamps for ordering")
 	earliestFlag := flag.String("earliest", "", "Day at which to start checking issuances "+
-		"(inclusive). Formatted like '20060102' Optional. If specified, -latest is required.")
+		"(inclusive). Accepts a day ('20060102'), an RFC3339 timestamp, or a duration ago "+
+		"(e.g. '36h' or '7d'). Optional. If specified, -latest (or -until) is required.")
 	latestFlag := flag.String("latest", "", "Day at which to stop checking issuances "+
-		"(exclusive). Formatted like '20060102'. Optional. If specified, -earliest is required.")
+		"(exclusive). Accepts the same formats as -earliest. Optional. If specified, "+
+		"-earliest is required.")
+	untilFlag := flag.String("until", "", "Alias for -latest.")
+	follow := flag.Bool("follow", false, "Instead of exiting, tail the RA and VA logs "+
+		"forever, emitting a JSON alert to stdout for any issuance not covered by a CAA "+
+		"check within -max-wait. Survives log rotation.")
+	maxWait := flag.Duration("max-wait", 24*time.Hour, "In -follow mode, how long to wait "+
+		"for a covering CAA check to show up before alerting on an issuance")
+	reportInvalid := flag.Bool("report-invalid", false, "Batch mode only (not -follow). "+
+		"Report issuances for which a CAA check was found but explicitly denied issuance "+
+		"(Valid for issuance: false) as a distinct finding, instead of silently treating "+
+		"them as if no CAA check had happened")
+	outputFormat := flag.String("output-format", "text", "Batch mode only (not -follow). "+
+		"Report format: \"text\" (human-readable, the default), \"ndjson\" (one JSON "+
+		"finding per line, to stdout), or \"junit\" (a JUnit XML report, written to "+
+		"-junit-file)")
+	junitFile := flag.String("junit-file", "", "Batch mode only (not -follow). Path to "+
+		"write the JUnit XML report to. Required when -output-format=junit")
+	metricsAddr := flag.String("metrics-addr", "", "If set, serve Prometheus metrics on "+
+		"this address (e.g. ':8080') at /metrics")
+	metricsWait := flag.Duration("metrics-wait", 0, "In one-shot mode (i.e. without -follow), "+
+		"how long to keep the -metrics-addr server up after processing finishes, so that a "+
+		"final scrape can complete, before exiting. Ignored in -follow mode, where the "+
+		"metrics server already runs for the lifetime of the process. Has no effect unless "+
+		"-metrics-addr is also set.")
 
 	flag.Parse()
 
 	if *timeTolerance < 0 {
 		cmd.Fail("value of -time-tolerance must be non-negative")
 	}
+	if *maxWait <= 0 {
+		cmd.Fail("value of -max-wait must be positive")
+	}
+	if *concurrency <= 0 {
+		cmd.Fail("value of -concurrency must be positive")
+	}
+	if *metricsWait < 0 {
+		cmd.Fail("value of -metrics-wait must be non-negative")
+	}
+	switch *outputFormat {
+	case "text", "ndjson", "junit":
+	default:
+		cmd.Fail(fmt.Sprintf("unrecognized -output-format %q", *outputFormat))
+	}
+	if *outputFormat == "junit" && *junitFile == "" {
+		cmd.Fail("-junit-file is required when -output-format=junit")
+	}
+	if *follow {
+		// -follow mode always emits a single alertRecord JSON shape, tailored to
+		// streaming; it has no notion of a batch-style report format, and no
+		// separate tracking of invalid-vs-missing CAA checks. Rather than
+		// silently ignoring these flags, fail fast so a user relying on them
+		// finds out immediately instead of from an incomplete report.
+		if *reportInvalid {
+			cmd.Fail("-report-invalid is not supported together with -follow")
+		}
+		if *outputFormat != "text" {
+			cmd.Fail("-output-format is not supported together with -follow")
+		}
+		if *junitFile != "" {
+			cmd.Fail("-junit-file is not supported together with -follow")
+		}
+	}
+
+	if *latestFlag != "" && *untilFlag != "" {
+		cmd.Fail("-latest and -until are aliases for the same flag; set only one")
+	}
+	effectiveLatestFlag := *latestFlag
+	if *untilFlag != "" {
+		effectiveLatestFlag = *untilFlag
+	}
 
 	var earliest time.Time
 	var latest time.Time
-	if *earliestFlag != "" || *latestFlag != "" {
-		if *earliestFlag == "" || *latestFlag == "" {
-			cmd.Fail("-earliest and -latest must be both set or both unset")
+	if *earliestFlag != "" || effectiveLatestFlag != "" {
+		if *earliestFlag == "" || effectiveLatestFlag == "" {
+			cmd.Fail("-earliest and -latest (or -until) must be both set or both unset")
 		}
+		now := time.Now()
 		var err error
-		earliest, err = time.Parse("20060102", *earliestFlag)
-		cmd.FailOnError(err, "value of -earliest could not be parsed as date")
-		latest, err = time.Parse("20060102", *latestFlag)
-		cmd.FailOnError(err, "value of -latest could not be parsed as date")
+		earliest, err = parseTimeFlag(*earliestFlag, now)
+		cmd.FailOnError(err, "value of -earliest could not be parsed")
+		latest, err = parseTimeFlag(effectiveLatestFlag, now)
+		cmd.FailOnError(err, "value of -latest could not be parsed")
 
 		if earliest.After(latest) {
 			cmd.Fail("earliest date must be before latest date")
@@ -241,17 +284,76 @@ func main() {
 		SyslogLevel: *logSyslogLevel,
 	})
 
+	var m *metrics
+	if *metricsAddr != "" {
+		m = newMetrics()
+		startMetricsServer(*metricsAddr, m)
+	}
+
+	raPaths, err := expandPaths(*raLog)
+	cmd.FailOnError(err, "failed to expand -ra-log")
+	vaPaths, err := expandPaths(*vaLogs)
+	cmd.FailOnError(err, "failed to expand -va-logs")
+
+	if *follow {
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+		err := runFollow(ctx, followConfig{
+			raLogs:        raPaths,
+			vaLogs:        vaPaths,
+			timeTolerance: *timeTolerance,
+			maxWait:       *maxWait,
+			out:           os.Stdout,
+			metrics:       m,
+		})
+		cmd.FailOnError(err, "follow mode exited with error")
+		return
+	}
+
 	// Build a map from hostnames to times at which those names were issued for.
-	issuanceMap, err := loadIssuanceLog(*raLog)
+	// This is a two-phase process: first all issuances are loaded, then all VA
+	// logs are scanned concurrently so that a CAA check from any VA log file
+	// can cover an issuance from any RA log file, regardless of which order the
+	// files are processed in.
+	issuanceMap, err := loadIssuanceLogs(raPaths, earliest, latest, *concurrency, m)
 	cmd.FailOnError(err, "failed to load issuance logs")
 
-	// Try to pare the issuance map down to nothing by removing every entry which
-	// is covered by a CAA check.
-	for _, vaLog := range strings.Split(*vaLogs, ",") {
-		err = processCAALog(vaLog, issuanceMap)
-		cmd.FailOnError(err, "failed to process CAA checking logs")
+	invalidFindings, err := processCAALogs(vaPaths, issuanceMap, earliest, latest, *concurrency, *timeTolerance, *reportInvalid, m)
+	cmd.FailOnError(err, "failed to process CAA checking logs")
+
+	if m != nil {
+		m.uncovered.WithLabelValues("no-caa-check").Add(float64(countRecords(issuanceMap)))
+		m.uncovered.WithLabelValues("caa-invalid").Add(float64(countRecords(invalidFindings)))
+	}
+
+	// In one-shot mode, the process is about to exit, which would otherwise
+	// tear down the -metrics-addr server before a scraper ever got a chance to
+	// read the results of this run. -metrics-wait keeps it up for one last
+	// scrape; this happens regardless of whether the run below ultimately
+	// succeeds or fails, so that a failing CI run's metrics are observable too.
+	if m != nil && *metricsWait > 0 {
+		time.Sleep(*metricsWait)
 	}
 
-	err = formatErrors(issuanceMap)
-	cmd.FailOnError(err, "the following issuances were missing CAA checks")
+	if *outputFormat == "text" {
+		err = formatErrors(issuanceMap, invalidFindings)
+		cmd.FailOnError(err, "the following issuances were missing CAA checks")
+		return
+	}
+
+	findings := buildFindings(issuanceMap, invalidFindings)
+	switch *outputFormat {
+	case "ndjson":
+		err = writeNDJSONReport(os.Stdout, findings)
+		cmd.FailOnError(err, "failed to write ndjson report")
+	case "junit":
+		f, err := os.Create(*junitFile)
+		cmd.FailOnError(err, "failed to create -junit-file")
+		err = writeJUnitReport(f, findings)
+		f.Close()
+		cmd.FailOnError(err, "failed to write junit report")
+	}
+	if len(findings) > 0 {
+		cmd.Fail(fmt.Sprintf("%d issuance(s) failed CAA verification", len(findings)))
+	}
 }
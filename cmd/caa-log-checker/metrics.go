@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus instrumentation exposed when -metrics-addr is
+// set, for both one-shot and -follow mode runs.
+type metrics struct {
+	registry *prometheus.Registry
+
+	issuances   prometheus.Counter
+	uncovered   *prometheus.CounterVec
+	caaCheckLag prometheus.Histogram
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		issuances: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "caa_log_checker_issuances_total",
+			Help: "Total number of issuances seen in RA logs",
+		}),
+		uncovered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "caa_log_checker_uncovered_total",
+			Help: "Total number of issuances not covered by a valid CAA check, by reason",
+		}, []string{"reason"}),
+		caaCheckLag: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "caa_log_checker_caa_check_lag_seconds",
+			Help:    "Time between an issuance and the CAA check that covered it",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 16),
+		}),
+	}
+	m.registry.MustRegister(m.issuances, m.uncovered, m.caaCheckLag)
+	return m
+}
+
+func (m *metrics) observeCAACheckLag(d time.Duration) {
+	m.caaCheckLag.Observe(d.Seconds())
+}
+
+// startMetricsServer serves m's registry at /metrics on addr in the
+// background. A failure to bind is logged to stderr rather than treated as
+// fatal: the metrics server is an adjunct to the checker's correctness, not
+// a prerequisite for it.
+func startMetricsServer(addr string, m *metrics) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "caa-log-checker: metrics server on %s failed: %v\n", addr, err)
+		}
+	}()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// expandPaths turns a comma-separated list of paths and shell globs (e.g.
+// "/var/log/boulder-va*.log.gz,/var/log/boulder-va-old.log") into a
+// deduplicated, sorted list of concrete file paths. A piece that doesn't
+// match any glob (because it contains no wildcard, or because the glob
+// simply has no matches yet) is passed through unchanged, so that opening it
+// later produces a normal "no such file" error rather than silently
+// processing zero files.
+func expandPaths(commaSeparated string) ([]string, error) {
+	seen := map[string]bool{}
+	var paths []string
+	for _, piece := range strings.Split(commaSeparated, ",") {
+		piece = strings.TrimSpace(piece)
+		if piece == "" {
+			continue
+		}
+		matches, err := filepath.Glob(piece)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob %q: %w", piece, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{piece}
+		}
+		for _, m := range matches {
+			if !seen[m] {
+				seen[m] = true
+				paths = append(paths, m)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// loadIssuanceLogs is the multi-file counterpart to loadIssuanceLog. It loads
+// every path in paths, fanning out at most concurrency goroutines at a time,
+// and merges their results into a single map. If m is non-nil, the total
+// number of issuances loaded is added to its issuances counter.
+func loadIssuanceLogs(paths []string, earliest, latest time.Time, concurrency int, m *metrics) (map[string][]issuanceRecord, error) {
+	result := map[string][]issuanceRecord{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, concurrency)
+	errs := make(chan error, len(paths))
+
+	for _, path := range paths {
+		path := path
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			records, issuancesCount, err := loadIssuanceLog(path, earliest, latest)
+			if err != nil {
+				errs <- err
+				return
+			}
+			mu.Lock()
+			for name, r := range records {
+				result[name] = append(result[name], r...)
+			}
+			if m != nil {
+				m.issuances.Add(float64(issuancesCount))
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// scanCAALog scans a single VA log file, or, if path is a directory, every
+// rotated log file in it concatenated in timestamp order, for CAA check
+// lines in [earliest, latest), sending a caaCheck for each one to out. Each
+// file's compression, if any, is detected from its contents rather than its
+// name. Unlike the old processCAALog, it does not touch the issuance map
+// directly, which is what allows many VA log files to be scanned
+// concurrently: the checks it produces are applied to the shared issuance
+// map by a single collector goroutine in processCAALogs.
+func scanCAALog(path string, earliest, latest time.Time, out chan<- caaCheck) error {
+	ls, err := openLogSource(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %q: %w", path, err)
+	}
+
+	for ls.Scan() {
+		line := ls.Text()
+
+		check, ok, err := parseCAACheck(line)
+		if err != nil {
+			return fmt.Errorf("%s: line %d: %w", ls.File(), ls.Line(), err)
+		}
+		if !ok || !inRange(check.time, earliest, latest) {
+			continue
+		}
+
+		out <- check
+	}
+
+	return ls.Err()
+}
+
+// applyCAACover applies a single, valid CAA check to the issuance map, the
+// same way processCAALog used to do inline: it evicts any issuance for the
+// checked name that the check covers, and, if the check found no CAA records
+// present, propagates that coverage up to the checked name's parent domains
+// as well. It must only be called for checks with valid == true; an invalid
+// check (one that denied issuance) doesn't cover anything. tolerance is the
+// same slop allowance as -time-tolerance applies in -follow mode. If m is
+// non-nil, the gap between each covered issuance and the check that covered
+// it is observed in its CAA-check-lag histogram.
+func applyCAACover(issuances map[string][]issuanceRecord, check caaCheck, tolerance time.Duration, m *metrics) {
+	if m != nil {
+		for _, record := range findCovered(issuances[check.name], check.time, tolerance) {
+			m.observeCAACheckLag(record.time.Sub(check.time))
+		}
+	}
+
+	issuances[check.name] = removeCovered(issuances[check.name], check.time, tolerance)
+	if len(issuances[check.name]) == 0 {
+		delete(issuances, check.name)
+	}
+
+	if !check.present {
+		labels := strings.Split(check.name, ".")
+		for i := 1; i < len(labels)-1; i++ {
+			tailName := strings.Join(labels[i:], ".")
+			issuances[tailName] = removeCovered(issuances[tailName], check.time, tolerance)
+			if len(issuances[tailName]) == 0 {
+				delete(issuances, tailName)
+			}
+		}
+	}
+}
+
+// processCAALogs is the multi-file, concurrent counterpart to scanCAALog.
+// It scans every VA log in paths, fanning out at most concurrency goroutines
+// at a time, and applies the valid CAA checks found to issuances regardless
+// of which file or in what order they were found in: a CAA check from any VA
+// log file can cover an issuance from any RA log file. tolerance is the same
+// slop allowance as -time-tolerance applies in -follow mode. If reportInvalid
+// is set, it additionally returns a map of names to issuances that were
+// covered by an *invalid* CAA check, i.e. one that denied issuance.
+func processCAALogs(paths []string, issuances map[string][]issuanceRecord, earliest, latest time.Time, concurrency int, tolerance time.Duration, reportInvalid bool, m *metrics) (map[string][]issuanceRecord, error) {
+	checks := make(chan caaCheck, concurrency)
+	errs := make(chan error, len(paths))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	// The launcher itself must run in its own goroutine, not the one that
+	// drains checks below: sem <- struct{}{} blocks once len(paths) exceeds
+	// concurrency, and a worker can only release its slot after every check it
+	// finds has been sent to checks. If the launcher ran inline here, nothing
+	// would be draining checks yet, so a worker that finds more than
+	// cap(checks) checks before the drain loop starts would block forever,
+	// and the launcher would never reach the next path to free up a slot.
+	go func() {
+		for _, path := range paths {
+			path := path
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				errs <- scanCAALog(path, earliest, latest, checks)
+			}()
+		}
+		wg.Wait()
+		close(checks)
+		close(errs)
+	}()
+
+	// Applying checks here, rather than in the scanning goroutines, serializes
+	// writes to the shared issuance map without needing a mutex.
+	var invalidChecks []caaCheck
+	for check := range checks {
+		if check.valid {
+			applyCAACover(issuances, check, tolerance, m)
+		} else if reportInvalid {
+			invalidChecks = append(invalidChecks, check)
+		}
+	}
+
+	for err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !reportInvalid {
+		return nil, nil
+	}
+	return extractInvalidFindings(issuances, invalidChecks, tolerance), nil
+}
+
+// extractInvalidFindings removes from issuances, and returns separately, any
+// issuance that is covered by (i.e. less than 8 hours, plus or minus
+// tolerance, after) one of invalidChecks. This lets -report-invalid classify
+// these issuances as "issued despite CAA saying no" instead of lumping them
+// in with issuances that had no CAA check at all.
+func extractInvalidFindings(issuances map[string][]issuanceRecord, invalidChecks []caaCheck, tolerance time.Duration) map[string][]issuanceRecord {
+	invalidFindings := map[string][]issuanceRecord{}
+	for _, check := range invalidChecks {
+		covered := findCovered(issuances[check.name], check.time, tolerance)
+		if len(covered) == 0 {
+			continue
+		}
+
+		invalidFindings[check.name] = append(invalidFindings[check.name], covered...)
+		issuances[check.name] = removeCovered(issuances[check.name], check.time, tolerance)
+		if len(issuances[check.name]) == 0 {
+			delete(issuances, check.name)
+		}
+	}
+	return invalidFindings
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpandPaths(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatalf("writing %s: %v", name, err)
+		}
+	}
+
+	got, err := expandPaths(filepath.Join(dir, "*.log") + "," + filepath.Join(dir, "a.log") + "," + filepath.Join(dir, "missing.log"))
+	if err != nil {
+		t.Fatalf("expandPaths: %v", err)
+	}
+
+	want := []string{filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log"), filepath.Join(dir, "missing.log")}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("got[%d] = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// TestProcessCAALogsConcurrencyDoesNotDeadlock is a regression test for a
+// deadlock where the goroutine draining the checks channel was the same
+// goroutine launching worker goroutines: once more VA log files were
+// supplied than -concurrency allowed in flight, the launcher blocked
+// acquiring a semaphore slot before the drain loop it was supposed to run
+// next ever started, and a worker with more matching checks than fit in the
+// checks buffer could never finish and free its slot.
+func TestProcessCAALogsConcurrencyDoesNotDeadlock(t *testing.T) {
+	dir := t.TempDir()
+	var paths []string
+	for i := 0; i < 6; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("va-%d.log", i))
+		var contents []byte
+		for j := 0; j < 50; j++ {
+			contents = append(contents, []byte(testTimestamp+" Checked CAA records for example.com, [Present: true, Valid for issuance: true]\n")...)
+		}
+		if err := os.WriteFile(path, contents, 0o644); err != nil {
+			t.Fatalf("writing %s: %v", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	issuances := map[string][]issuanceRecord{}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := processCAALogs(paths, issuances, time.Time{}, time.Time{}, 2, 0, false, nil)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("processCAALogs: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("processCAALogs did not complete within 5s; likely deadlocked")
+	}
+}
+
+func TestProcessCAALogsCoversIssuance(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "va.log")
+	line := testTimestamp + " Checked CAA records for example.com, [Present: true, Valid for issuance: true]\n"
+	if err := os.WriteFile(path, []byte(line), 0o644); err != nil {
+		t.Fatalf("writing va log: %v", err)
+	}
+
+	checkTime, err := parseTimestamp(testTimestamp)
+	if err != nil {
+		t.Fatalf("parsing test timestamp: %v", err)
+	}
+
+	issuances := map[string][]issuanceRecord{
+		"example.com": {{time: checkTime.Add(time.Hour), serial: "01", requester: 1}},
+	}
+
+	invalid, err := processCAALogs([]string{path}, issuances, time.Time{}, time.Time{}, 4, 0, false, nil)
+	if err != nil {
+		t.Fatalf("processCAALogs: %v", err)
+	}
+	if invalid != nil {
+		t.Errorf("invalid = %v, want nil (reportInvalid was not set)", invalid)
+	}
+	if len(issuances["example.com"]) != 0 {
+		t.Errorf("issuances[example.com] = %v, want empty (covered by the check)", issuances["example.com"])
+	}
+}
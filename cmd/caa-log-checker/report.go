@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// finding is a single issuance that failed CAA verification, in the
+// structured shape shared by the ndjson and junit report formats.
+type finding struct {
+	Time      time.Time
+	Name      string
+	Serial    string
+	Requester int64
+	// Reason is either "no-caa-check" (no CAA check covered the issuance at
+	// all) or "caa-invalid" (a CAA check covered it, but denied issuance).
+	Reason string
+}
+
+// countRecords returns the total number of issuance records across all names
+// in m.
+func countRecords(m map[string][]issuanceRecord) int {
+	n := 0
+	for _, records := range m {
+		n += len(records)
+	}
+	return n
+}
+
+// buildFindings flattens the uncovered and invalid issuance maps produced by
+// loadIssuanceLogs/processCAALogs into a single, time-sorted list of
+// findings, for use by the ndjson and junit report formats.
+func buildFindings(uncovered, invalid map[string][]issuanceRecord) []finding {
+	var findings []finding
+	for name, records := range uncovered {
+		for _, r := range records {
+			findings = append(findings, finding{
+				Time: r.time, Name: name, Serial: r.serial, Requester: r.requester,
+				Reason: "no-caa-check",
+			})
+		}
+	}
+	for name, records := range invalid {
+		for _, r := range records {
+			findings = append(findings, finding{
+				Time: r.time, Name: name, Serial: r.serial, Requester: r.requester,
+				Reason: "caa-invalid",
+			})
+		}
+	}
+
+	sort.Slice(findings, func(i, j int) bool {
+		if !findings[i].Time.Equal(findings[j].Time) {
+			return findings[i].Time.Before(findings[j].Time)
+		}
+		return findings[i].Name < findings[j].Name
+	})
+	return findings
+}
+
+// ndjsonFinding is the on-the-wire shape of a single line of ndjson output.
+type ndjsonFinding struct {
+	Time      time.Time `json:"time"`
+	Name      string    `json:"name"`
+	Serial    string    `json:"serial"`
+	Requester int64     `json:"requester"`
+	Reason    string    `json:"reason"`
+}
+
+// writeNDJSONReport writes one JSON object per finding, newline-delimited,
+// to w.
+func writeNDJSONReport(w io.Writer, findings []finding) error {
+	enc := json.NewEncoder(w)
+	for _, f := range findings {
+		err := enc.Encode(ndjsonFinding{
+			Time:      f.Time,
+			Name:      f.Name,
+			Serial:    f.Serial,
+			Requester: f.Requester,
+			Reason:    f.Reason,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type junitTestsuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Testcases []junitTestcase `xml:"testcase"`
+}
+
+type junitTestcase struct {
+	Name      string       `xml:"name,attr"`
+	Classname string       `xml:"classname,attr"`
+	Failure   junitFailure `xml:"failure"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+var reasonMessages = map[string]string{
+	"no-caa-check": "issued without any CAA check",
+	"caa-invalid":  "issued despite CAA check denying issuance",
+}
+
+// writeJUnitReport writes findings as a JUnit XML report, with one failing
+// testcase per finding, suitable for consumption by CI dashboards.
+func writeJUnitReport(w io.Writer, findings []finding) error {
+	suite := junitTestsuite{
+		Name:     "caa-log-checker",
+		Tests:    len(findings),
+		Failures: len(findings),
+	}
+	for _, f := range findings {
+		suite.Testcases = append(suite.Testcases, junitTestcase{
+			Name:      fmt.Sprintf("%s @ %s", f.Name, f.Time.Format(time.RFC3339)),
+			Classname: "caa-log-checker",
+			Failure: junitFailure{
+				Message: reasonMessages[f.Reason],
+				Text:    fmt.Sprintf("serial=%s requester=%d", f.Serial, f.Requester),
+			},
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildFindings(t *testing.T) {
+	t1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	t2 := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	uncovered := map[string][]issuanceRecord{
+		"b.example.com": {{time: t2, serial: "02", requester: 2}},
+	}
+	invalid := map[string][]issuanceRecord{
+		"a.example.com": {{time: t1, serial: "01", requester: 1}},
+	}
+
+	findings := buildFindings(uncovered, invalid)
+	if len(findings) != 2 {
+		t.Fatalf("got %d findings, want 2: %+v", len(findings), findings)
+	}
+	// buildFindings sorts by time, so the caa-invalid finding (t1) should come
+	// before the no-caa-check one (t2) despite the reverse insertion order above.
+	if findings[0].Name != "a.example.com" || findings[0].Reason != "caa-invalid" {
+		t.Errorf("findings[0] = %+v, want a.example.com/caa-invalid", findings[0])
+	}
+	if findings[1].Name != "b.example.com" || findings[1].Reason != "no-caa-check" {
+		t.Errorf("findings[1] = %+v, want b.example.com/no-caa-check", findings[1])
+	}
+}
+
+func TestWriteNDJSONReport(t *testing.T) {
+	findings := []finding{
+		{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Name: "example.com", Serial: "01", Requester: 1, Reason: "no-caa-check"},
+		{Time: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC), Name: "other.com", Serial: "02", Requester: 2, Reason: "caa-invalid"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeNDJSONReport(&buf, findings); err != nil {
+		t.Fatalf("writeNDJSONReport: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimRight(buf.String(), "\n"), "\n")
+	if len(lines) != len(findings) {
+		t.Fatalf("got %d lines, want %d: %q", len(lines), len(findings), buf.String())
+	}
+	for _, want := range []string{`"name":"example.com"`, `"serial":"01"`, `"reason":"no-caa-check"`} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("line 0 %q missing %q", lines[0], want)
+		}
+	}
+}
+
+func TestWriteJUnitReport(t *testing.T) {
+	findings := []finding{
+		{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Name: "example.com", Serial: "01", Requester: 1, Reason: "caa-invalid"},
+	}
+
+	var buf bytes.Buffer
+	if err := writeJUnitReport(&buf, findings); err != nil {
+		t.Fatalf("writeJUnitReport: %v", err)
+	}
+
+	var suite junitTestsuite
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("unmarshaling junit output: %v", err)
+	}
+	if suite.Tests != 1 || suite.Failures != 1 {
+		t.Fatalf("suite = %+v, want Tests=1 Failures=1", suite)
+	}
+	if len(suite.Testcases) != 1 || suite.Testcases[0].Failure.Message != reasonMessages["caa-invalid"] {
+		t.Fatalf("testcases = %+v", suite.Testcases)
+	}
+}
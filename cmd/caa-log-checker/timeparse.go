@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var dayDurationRE = regexp.MustCompile(`^([0-9]+)d$`)
+
+// parseTimeFlag parses the value of a flag like -earliest, -latest, or
+// -until. The value may be given three ways:
+//
+//   - a day, formatted like "20060102";
+//   - an absolute instant, in RFC3339 format;
+//   - a duration ago, relative to now. This may be a Go duration string like
+//     "90m" or "36h", or an integer number of days like "7d" (a unit that
+//     time.ParseDuration doesn't understand).
+//
+// This mirrors the behavior of `-since`/`-until` in tools like `docker logs`
+// and `podman logs`.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse("20060102", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if d, err := parseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("value %q is not a day (e.g. 20060102), an RFC3339 "+
+		"timestamp, or a duration ago (e.g. 36h or 7d)", value)
+}
+
+// parseDuration parses a Go duration string (e.g. "90m", "36h"), with the
+// addition of a "d" (day) unit, which time.ParseDuration doesn't support. A
+// negative duration is rejected: parseTimeFlag always subtracts the result
+// from now, so a negative value (which time.ParseDuration otherwise accepts,
+// e.g. "-3h") would silently flip direction and produce a time in the
+// future rather than the past.
+func parseDuration(value string) (time.Duration, error) {
+	if matches := dayDurationRE.FindStringSubmatch(value); matches != nil {
+		days, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("duration %q must not be negative", value)
+	}
+	return d, nil
+}
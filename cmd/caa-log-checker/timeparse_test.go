@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{name: "hours", value: "36h", want: 36 * time.Hour},
+		{name: "days", value: "7d", want: 7 * 24 * time.Hour},
+		{name: "zero days", value: "0d", want: 0},
+		{name: "negative duration is rejected", value: "-3h", wantErr: true},
+		{name: "negative days don't match the day format", value: "-3d", wantErr: true},
+		{name: "garbage", value: "not-a-duration", wantErr: true},
+		{name: "empty", value: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDuration(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeFlag(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "day format", value: "20240101", want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{name: "rfc3339", value: "2024-01-10T00:00:00Z", want: time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)},
+		{name: "hours ago", value: "36h", want: now.Add(-36 * time.Hour)},
+		{name: "days ago", value: "7d", want: now.Add(-7 * 24 * time.Hour)},
+		{name: "negative duration is rejected, not sign-flipped into the future", value: "-3h", wantErr: true},
+		{name: "malformed", value: "not-a-time", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTimeFlag(tt.value, now)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}